@@ -18,10 +18,17 @@ package e2e
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
 	"strconv"
+	"sync"
 	"time"
 
 	"k8s.io/kubernetes/pkg/api"
+	apierrs "k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/client/cache"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/labels"
@@ -64,43 +71,183 @@ func nodeExec(nodeName, cmd string) (framework.SSHResult, error) {
 	return result, err
 }
 
+// Backend selects how a restartDaemonConfig reaches its target to run
+// commands against it.
+type Backend int
+
+const (
+	// BackendSSH execs over SSH via framework.SSH. Requires a provider in
+	// framework.ProvidersWithSSH.
+	BackendSSH Backend = iota
+	// BackendHostExec execs into the privileged host-exec shim pod
+	// colocated with the target (see ensureHostExecShim), via the API
+	// server. Works on any conformant cluster, including hosted control
+	// planes where master SSH isn't available.
+	BackendHostExec
+)
+
+// defaultBackend picks BackendSSH on providers known to support node/master
+// SSH, and falls back to BackendHostExec everywhere else.
+func defaultBackend() Backend {
+	if framework.ProviderIs(framework.ProvidersWithSSH...) {
+		return BackendSSH
+	}
+	return BackendHostExec
+}
+
 // restartDaemonConfig is a config to restart a running daemon on a node, and wait till
-// it comes back up. It uses ssh to send a SIGTERM to the daemon.
+// it comes back up. Depending on Backend, it reaches the daemon's node either over ssh
+// or by exec'ing into a host-exec shim pod colocated with it.
 type restartDaemonConfig struct {
-	nodeName     string
+	client *client.Client
+	ns     string
+
+	// nodeName addresses a node-local daemon directly, e.g. the kubelet.
+	nodeName string
+	// podLabelSelector addresses a control-plane component by the label on
+	// its pod instead, since master nodes aren't always SSH-able or even
+	// registered as Node objects (e.g. on hosted control planes). Mutually
+	// exclusive with nodeName.
+	podLabelSelector labels.Selector
+
 	daemonName   string
 	healthzPort  int
 	pollInterval time.Duration
 	pollTimeout  time.Duration
+	backend      Backend
 }
 
-// NewRestartConfig creates a restartDaemonConfig for the given node and daemon.
-func NewRestartConfig(nodeName, daemonName string, healthzPort int, pollInterval, pollTimeout time.Duration) *restartDaemonConfig {
-	if !framework.ProviderIs("gce") {
+// NewRestartConfig creates a restartDaemonConfig for the daemon running on the given
+// node. The backend defaults to SSH on providers known to support it, and to the
+// host-exec shim everywhere else.
+func NewRestartConfig(c *client.Client, ns, nodeName, daemonName string, healthzPort int, pollInterval, pollTimeout time.Duration) *restartDaemonConfig {
+	backend := defaultBackend()
+	if backend == BackendSSH && !framework.ProviderIs("gce") {
 		framework.Logf("WARNING: SSH through the restart config might not work on %s", framework.TestContext.Provider)
 	}
 	return &restartDaemonConfig{
+		client:       c,
+		ns:           ns,
 		nodeName:     nodeName,
 		daemonName:   daemonName,
 		healthzPort:  healthzPort,
 		pollInterval: pollInterval,
 		pollTimeout:  pollTimeout,
+		backend:      backend,
+	}
+}
+
+// NewRestartConfigForControlPlaneComponent creates a restartDaemonConfig for a
+// control-plane component addressed by componentLabel (e.g.
+// "component=kube-controller-manager") rather than by node name.
+// framework.GetMasterHost is meaningless on hosted control planes, so this always
+// uses the host-exec backend and execs into the shim pod colocated with whichever
+// pod currently matches componentLabel.
+func NewRestartConfigForControlPlaneComponent(c *client.Client, ns, daemonName, componentLabel string, healthzPort int, pollInterval, pollTimeout time.Duration) *restartDaemonConfig {
+	selector, err := labels.Parse(componentLabel)
+	framework.ExpectNoError(err, "parsing control-plane component label selector %q", componentLabel)
+	return &restartDaemonConfig{
+		client:           c,
+		ns:               ns,
+		podLabelSelector: selector,
+		daemonName:       daemonName,
+		healthzPort:      healthzPort,
+		pollInterval:     pollInterval,
+		pollTimeout:      pollTimeout,
+		backend:          BackendHostExec,
 	}
 }
 
+// kubeletRestartTargets returns the identifier each per-node kubelet
+// restartDaemonConfig should be built with: public IPs for BackendSSH, which
+// nodeExec dials directly, or Node object names for BackendHostExec, which
+// shimPodOnNode matches against pod.Spec.NodeName. The two are not
+// interchangeable, so which one to return depends on the resolved backend.
+func kubeletRestartTargets(f *framework.Framework) ([]string, error) {
+	if defaultBackend() == BackendSSH {
+		return getNodePublicIps(f.ClientSet)
+	}
+	nodes, err := f.Client.Nodes().List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
+// newControlPlaneRestarter builds a restartDaemonConfig for a control-plane
+// component. On providers with master SSH it targets framework.GetMasterHost
+// directly, same as before; everywhere else GetMasterHost is meaningless (no
+// SSH-able master, possibly no master Node object at all), so it targets the
+// component's pod by componentLabel via the host-exec backend instead. On a
+// genuinely hosted control plane, componentLabel may not match anything at
+// all (the component isn't run as a visible pod) - that's not a bug in this
+// suite, so the spec is skipped rather than failed.
+func newControlPlaneRestarter(c *client.Client, ns, daemonName, componentLabel string, healthzPort int) *restartDaemonConfig {
+	if defaultBackend() == BackendSSH {
+		return NewRestartConfig(c, ns, framework.GetMasterHost(), daemonName, healthzPort, restartPollInterval, restartTimeout)
+	}
+	selector, err := labels.Parse(componentLabel)
+	framework.ExpectNoError(err, "parsing control-plane component label selector %q", componentLabel)
+	pods, err := c.Pods(api.NamespaceSystem).List(api.ListOptions{LabelSelector: selector})
+	framework.ExpectNoError(err)
+	if len(pods.Items) == 0 {
+		framework.Skipf("no pod in %v matches %v; %v isn't visible as a pod on this control plane", api.NamespaceSystem, selector, daemonName)
+	}
+	return NewRestartConfigForControlPlaneComponent(c, ns, daemonName, componentLabel, healthzPort, restartPollInterval, restartTimeout)
+}
+
 func (r *restartDaemonConfig) String() string {
+	if r.podLabelSelector != nil {
+		return fmt.Sprintf("Daemon %v on the control-plane pod matching %v", r.daemonName, r.podLabelSelector)
+	}
 	return fmt.Sprintf("Daemon %v on node %v", r.daemonName, r.nodeName)
 }
 
+// targetNodeName resolves the node r's commands actually run against: its own
+// nodeName, or, for control-plane components addressed by label, the node
+// currently hosting whatever pod matches podLabelSelector.
+func (r *restartDaemonConfig) targetNodeName() (string, error) {
+	if r.podLabelSelector == nil {
+		return r.nodeName, nil
+	}
+	pods, err := r.client.Pods(api.NamespaceSystem).List(api.ListOptions{LabelSelector: r.podLabelSelector})
+	if err != nil {
+		return "", err
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pod in %v matches selector %v", api.NamespaceSystem, r.podLabelSelector)
+	}
+	return pods.Items[0].Spec.NodeName, nil
+}
+
+// exec runs cmd against r's target, over SSH for BackendSSH or inside the
+// host-exec shim pod colocated with the target for BackendHostExec.
+func (r *restartDaemonConfig) exec(cmd string) (framework.SSHResult, error) {
+	if r.backend == BackendSSH {
+		return nodeExec(r.nodeName, cmd)
+	}
+	nodeName, err := r.targetNodeName()
+	if err != nil {
+		return framework.SSHResult{}, err
+	}
+	return hostExec(r.client, r.ns, nodeName, cmd)
+}
+
 // waitUp polls healthz of the daemon till it returns "ok" or the polling hits the pollTimeout
 func (r *restartDaemonConfig) waitUp() {
 	framework.Logf("Checking if %v is up by polling for a 200 on its /healthz endpoint", r)
 	healthzCheck := fmt.Sprintf(
 		"curl -s -o /dev/null -I -w \"%%{http_code}\" http://localhost:%v/healthz", r.healthzPort)
 
-	err := wait.Poll(r.pollInterval, r.pollTimeout, func() (bool, error) {
-		result, err := nodeExec(r.nodeName, healthzCheck)
-		framework.ExpectNoError(err)
+	framework.Eventually(func() (bool, error) {
+		result, err := r.exec(healthzCheck)
+		if err != nil {
+			return false, err
+		}
 		if result.Code == 0 {
 			httpCode, err := strconv.Atoi(result.Stdout)
 			if err != nil {
@@ -109,17 +256,16 @@ func (r *restartDaemonConfig) waitUp() {
 				return true, nil
 			}
 		}
-		framework.Logf("node %v exec command, '%v' failed with exitcode %v: \n\tstdout: %v\n\tstderr: %v",
-			r.nodeName, healthzCheck, result.Code, result.Stdout, result.Stderr)
+		framework.Logf("%v exec command, '%v' failed with exitcode %v: \n\tstdout: %v\n\tstderr: %v",
+			r, healthzCheck, result.Code, result.Stdout, result.Stderr)
 		return false, nil
-	})
-	framework.ExpectNoError(err, "%v did not respond with a 200 via %v within %v", r, healthzCheck, r.pollTimeout)
+	}, r.pollTimeout, r.pollInterval, "%v did not respond with a 200 via %v", r, healthzCheck)
 }
 
 // kill sends a SIGTERM to the daemon
 func (r *restartDaemonConfig) kill() {
 	framework.Logf("Killing %v", r)
-	nodeExec(r.nodeName, fmt.Sprintf("pgrep %v | xargs -I {} sudo kill {}", r.daemonName))
+	r.exec(fmt.Sprintf("pgrep %v | xargs -I {} sudo kill {}", r.daemonName))
 }
 
 // Restart checks if the daemon is up, kills it, and waits till it comes back up
@@ -129,33 +275,422 @@ func (r *restartDaemonConfig) restart() {
 	r.waitUp()
 }
 
-// podTracker records a serial history of events that might've affects pods.
+// partition simulates a loss of connectivity between r's node and the API
+// server by installing a blackhole route to apiServerIP. Unlike kill, the
+// daemon itself keeps running throughout - this exercises split-brain-like
+// behavior (daemon alive but isolated) rather than a clean process restart.
+func (r *restartDaemonConfig) partition(apiServerIP string) {
+	framework.Logf("Partitioning %v from the apiserver at %v", r, apiServerIP)
+	r.exec(fmt.Sprintf("sudo ip route add blackhole %v", apiServerIP))
+}
+
+// heal removes the blackhole route installed by partition, restoring
+// connectivity between r's node and the API server.
+func (r *restartDaemonConfig) heal(apiServerIP string) {
+	framework.Logf("Healing the partition between %v and the apiserver at %v", r, apiServerIP)
+	r.exec(fmt.Sprintf("sudo ip route delete blackhole %v", apiServerIP))
+}
+
+// disrupt partitions r's node from the API server, holds the partition for
+// duration, and then heals it. It blocks until the node's Ready condition is
+// observed to flap away from True and then recover, so callers can be sure
+// the partition actually took effect and was actually healed rather than
+// just issuing the route commands. The heal step always runs, even if the
+// node never reports NotReady, so a failure here can't leave a node
+// permanently cut off from the API server.
+//
+// This is only meaningful on BackendSSH: partition's blackhole route cuts
+// off the node's side of the very TCP connections the API server uses to
+// open an exec stream into it, so on BackendHostExec the heal step would
+// have no channel left to run over and could strand the node forever. There
+// is no host-exec-based disruption mode; see ensureHostExecShim.
+func (r *restartDaemonConfig) disrupt(apiServerIP string, duration time.Duration) {
+	if r.backend != BackendSSH {
+		framework.Skipf("network-partition disruption requires BackendSSH: on BackendHostExec, healing would require an exec stream through the very route partition() just blackholed")
+	}
+
+	// r.targetNodeName() (podLabelSelector is always nil here, since SSH
+	// control-plane configs set nodeName directly rather than going through
+	// NewRestartConfigForControlPlaneComponent) is whatever address r.exec
+	// dials - a public IP or framework.GetMasterHost() - which is essentially
+	// never the same string as the Node object's own .Name. Resolve the real
+	// Node so isNodeReady can look it up by the name it's actually
+	// registered under.
+	dialAddr, err := r.targetNodeName()
+	framework.ExpectNoError(err)
+	node, err := findNodeByAddress(r.client, dialAddr)
+	if err != nil {
+		framework.Skipf("cannot verify Ready state across the partition: %v (common for classic masters, which are often not registered as Node objects at all)", err)
+	}
+	nodeName := node.Name
+
+	healed := false
+	defer func() {
+		if !healed {
+			r.heal(apiServerIP)
+		}
+	}()
+
+	r.partition(apiServerIP)
+	framework.Logf("Waiting for node %v to report NotReady while partitioned", nodeName)
+	err = wait.Poll(restartPollInterval, restartTimeout, func() (bool, error) {
+		return !isNodeReady(r.client, nodeName), nil
+	})
+	framework.ExpectNoError(err, "node %v never flapped to NotReady during the partition", nodeName)
+
+	time.Sleep(duration)
+
+	r.heal(apiServerIP)
+	healed = true
+	framework.Logf("Waiting for node %v to report Ready again after healing the partition", nodeName)
+	err = wait.Poll(restartPollInterval, restartTimeout, func() (bool, error) {
+		return isNodeReady(r.client, nodeName), nil
+	})
+	framework.ExpectNoError(err, "node %v did not recover Ready status after healing the partition", nodeName)
+}
+
+// findNodeByAddress returns the Node whose status.addresses includes addr
+// (an SSH-able hostname or IP, e.g. from getNodePublicIps or
+// framework.GetMasterHost), since that dial address is frequently not the
+// Node's own .Name.
+func findNodeByAddress(c *client.Client, addr string) (*api.Node, error) {
+	nodes, err := c.Nodes().List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		for _, nodeAddr := range node.Status.Addresses {
+			if nodeAddr.Address == addr {
+				return node, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no Node found with address %v", addr)
+}
+
+// isNodeReady returns whether the node's Ready condition is currently True.
+func isNodeReady(c *client.Client, nodeName string) bool {
+	node, err := c.Nodes().Get(nodeName)
+	framework.ExpectNoError(err)
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == api.NodeReady {
+			return cond.Status == api.ConditionTrue
+		}
+	}
+	return false
+}
+
+// apiServerIP returns the bare IP (no port) of the API server the test
+// framework is configured against, suitable for installing a blackhole route
+// to on a node. A hostname is resolved, and its first address is returned.
+func apiServerIP() (string, error) {
+	u, err := url.Parse(framework.TestContext.Host)
+	if err != nil {
+		return "", err
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(u.Host); err == nil {
+		host = h
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	addrs, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", fmt.Errorf("no IP address found for apiserver host %v", host)
+	}
+	return addrs[0].String(), nil
+}
+
+// hostExecShimName is the label value and DaemonSet/pod name prefix used by
+// ensureHostExecShim.
+const hostExecShimName = "daemon-restart-host-exec"
+
+// ensureHostExecShim creates (or reuses) a privileged DaemonSet that runs one
+// pod per node, with hostPID and the host's / and /var/run bind-mounted in.
+// restartDaemonConfig execs into the shim pod colocated with its target
+// instead of SSHing directly, which is what lets BackendHostExec work on any
+// conformant cluster. BeforeEach calls it before every spec, in that spec's
+// namespace; a DaemonSet of the same name already in ns is reused.
+func ensureHostExecShim(c *client.Client, ns string) {
+	privileged := true
+	ds := &extensions.DaemonSet{
+		ObjectMeta: api.ObjectMeta{
+			Name:      hostExecShimName,
+			Namespace: ns,
+		},
+		Spec: extensions.DaemonSetSpec{
+			Selector: &unversioned.LabelSelector{MatchLabels: map[string]string{"name": hostExecShimName}},
+			Template: api.PodTemplateSpec{
+				ObjectMeta: api.ObjectMeta{
+					Name:   hostExecShimName,
+					Labels: map[string]string{"name": hostExecShimName},
+				},
+				Spec: api.PodSpec{
+					HostPID: true,
+					// Control-plane components are exactly what BackendHostExec
+					// exists to reach (kube-controller-manager, kube-scheduler),
+					// and on kubeadm-style clusters the master node carries a
+					// NoSchedule taint that would otherwise keep this DaemonSet
+					// off of it. Tolerate every taint rather than naming a
+					// specific key, since the master taint's key has changed
+					// across releases and providers.
+					Tolerations: []api.Toleration{
+						{Operator: api.TolerationOpExists},
+					},
+					Containers: []api.Container{
+						{
+							Name:            "shim",
+							Image:           "busybox",
+							Command:         []string{"sleep", "999999999"},
+							SecurityContext: &api.SecurityContext{Privileged: &privileged},
+							VolumeMounts: []api.VolumeMount{
+								{Name: "rootfs", MountPath: "/rootfs"},
+								{Name: "varrun", MountPath: "/var/run"},
+							},
+						},
+					},
+					Volumes: []api.Volume{
+						{Name: "rootfs", VolumeSource: api.VolumeSource{HostPath: &api.HostPathVolumeSource{Path: "/"}}},
+						{Name: "varrun", VolumeSource: api.VolumeSource{HostPath: &api.HostPathVolumeSource{Path: "/var/run"}}},
+					},
+				},
+			},
+		},
+	}
+	if _, err := c.Extensions().DaemonSets(ns).Create(ds); err != nil && !apierrs.IsAlreadyExists(err) {
+		framework.ExpectNoError(err, "creating host-exec shim DaemonSet")
+	}
+
+	framework.Eventually(func() (bool, error) {
+		pods, err := c.Pods(ns).List(api.ListOptions{LabelSelector: labels.Set{"name": hostExecShimName}.AsSelector()})
+		if err != nil {
+			return false, err
+		}
+		if len(pods.Items) == 0 {
+			return false, nil
+		}
+		for _, p := range pods.Items {
+			if p.Status.Phase != api.PodRunning {
+				return false, nil
+			}
+		}
+		return true, nil
+	}, restartTimeout, restartPollInterval, "host-exec shim DaemonSet never became Ready in namespace %v", ns)
+}
+
+// shimPodOnNode returns the name of the host-exec shim pod scheduled on nodeName.
+func shimPodOnNode(c *client.Client, ns, nodeName string) (string, error) {
+	pods, err := c.Pods(ns).List(api.ListOptions{LabelSelector: labels.Set{"name": hostExecShimName}.AsSelector()})
+	if err != nil {
+		return "", err
+	}
+	for _, p := range pods.Items {
+		if p.Spec.NodeName == nodeName {
+			return p.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no host-exec shim pod scheduled on node %v", nodeName)
+}
+
+// hostExec runs cmd inside the host-exec shim pod scheduled on nodeName,
+// chrooted into the host's root filesystem so it behaves like an SSH session
+// on that node would.
+func hostExec(c *client.Client, ns, nodeName, cmd string) (framework.SSHResult, error) {
+	shimPod, err := shimPodOnNode(c, ns, nodeName)
+	if err != nil {
+		return framework.SSHResult{}, err
+	}
+	stdout, err := framework.RunHostCmd(ns, shimPod, fmt.Sprintf("chroot /rootfs /bin/sh -c %q", cmd))
+	if err == nil {
+		return framework.SSHResult{Code: 0, Stdout: stdout}, nil
+	}
+	if _, ok := err.(*exec.ExitError); ok {
+		// The command ran inside the shim and returned non-zero - that's a
+		// legitimate SSHResult, not a failure to exec at all.
+		return framework.SSHResult{Code: 1, Stdout: stdout, Stderr: err.Error()}, nil
+	}
+	// Couldn't run the command at all (shim pod gone, exec stream broken,
+	// apiserver proxy failure, ...). Propagate the error instead of
+	// swallowing it into a fake SSHResult, so framework.Eventually/Never
+	// fail fast on it rather than retrying a channel that can't succeed.
+	return framework.SSHResult{}, err
+}
+
+// PodInvariant is evaluated against every watch event a podTracker observes.
+// event is one of ADD/UPDATE/DEL, pod is the pod the event is about, and
+// history is every pod previously observed for that same name, oldest
+// first. A non-nil error means the invariant was violated by this event.
+type PodInvariant func(event string, pod *api.Pod, history []*api.Pod) error
+
+// podEvent is a single observation recorded by podTracker.
+type podEvent struct {
+	at        time.Time
+	eventType string
+	pod       *api.Pod
+}
+
+// podTracker records an ordered history of ADD/UPDATE/DEL events seen on a
+// set of watched pods, and evaluates any installed PodInvariants against
+// that history as each event arrives, rather than only at the end of a
+// test. Violations are buffered on a channel so the watch's own goroutine
+// never calls into Ginkgo directly; AssertInvariants' caller is expected to
+// drain Errors() (typically in AfterEach).
 type podTracker struct {
-	cache.ThreadSafeStore
+	mu         sync.Mutex
+	history    []podEvent
+	invariants []PodInvariant
+	errs       chan error
+}
+
+func newPodTracker() *podTracker {
+	return &podTracker{errs: make(chan error, 100)}
+}
+
+// AssertInvariants registers invariants to be checked against every event
+// from here on. It's additive: a test can call it more than once to layer on
+// more invariants as it progresses.
+func (p *podTracker) AssertInvariants(invariants ...PodInvariant) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.invariants = append(p.invariants, invariants...)
+}
+
+// Errors drains and returns every invariant violation observed so far.
+func (p *podTracker) Errors() []error {
+	var errs []error
+	for {
+		select {
+		case err := <-p.errs:
+			errs = append(errs, err)
+		default:
+			return errs
+		}
+	}
 }
 
 func (p *podTracker) remember(pod *api.Pod, eventType string) {
-	if eventType == UPDATE && pod.Status.Phase == api.PodRunning {
-		return
+	// Every UPDATE is recorded, even ones that leave Status.Phase at Running:
+	// a container restarting under RestartPolicy: Always bumps
+	// ContainerStatuses[i].RestartCount without ever moving the pod off of
+	// Running, so skipping "uninteresting" Running updates here would starve
+	// NoContainerRestarts of the very history it diffs against.
+	p.mu.Lock()
+	history := p.podHistoryLocked(pod.Name)
+	p.history = append(p.history, podEvent{at: time.Now(), eventType: eventType, pod: pod})
+	invariants := p.invariants
+	p.mu.Unlock()
+
+	for _, invariant := range invariants {
+		if err := invariant(eventType, pod, history); err != nil {
+			select {
+			case p.errs <- err:
+			default:
+				framework.Logf("podTracker invariant error buffer is full, dropping: %v", err)
+			}
+		}
 	}
-	p.Add(fmt.Sprintf("[%v] %v: %v", time.Now(), eventType, pod.Name), pod)
 }
 
-func (p *podTracker) String() (msg string) {
-	for _, k := range p.ListKeys() {
-		obj, exists := p.Get(k)
-		if !exists {
-			continue
+// podHistoryLocked returns every pod previously observed with the given
+// name, oldest first. Callers must hold p.mu.
+func (p *podTracker) podHistoryLocked(name string) []*api.Pod {
+	var history []*api.Pod
+	for _, e := range p.history {
+		if e.pod.Name == name {
+			history = append(history, e.pod)
 		}
-		pod := obj.(*api.Pod)
-		msg += fmt.Sprintf("%v Phase %v Host %v\n", k, pod.Status.Phase, pod.Spec.NodeName)
+	}
+	return history
+}
+
+// String renders the tracked history as a timeline ordered by observation
+// time, for failure diagnostics.
+func (p *podTracker) String() (msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, e := range p.history {
+		msg += fmt.Sprintf("[%v] %v: %v Phase %v Host %v\n", e.at, e.eventType, e.pod.Name, e.pod.Status.Phase, e.pod.Spec.NodeName)
 	}
 	return
 }
 
-func newPodTracker() *podTracker {
-	return &podTracker{cache.NewThreadSafeStore(
-		cache.Indexers{}, cache.Indices{})}
+// NoPodDeletionsDuring returns a PodInvariant that fails if any pod is
+// deleted within window of the moment the invariant is installed.
+func NoPodDeletionsDuring(window time.Duration) PodInvariant {
+	deadline := time.Now().Add(window)
+	return func(event string, pod *api.Pod, history []*api.Pod) error {
+		if event == DEL && time.Now().Before(deadline) {
+			return fmt.Errorf("pod %v was deleted within the %v no-deletion window", pod.Name, window)
+		}
+		return nil
+	}
+}
+
+// NoContainerRestarts returns a PodInvariant that fails the first time a
+// pod's total container restart count goes up relative to the last time
+// that pod was observed.
+func NoContainerRestarts() PodInvariant {
+	return func(event string, pod *api.Pod, history []*api.Pod) error {
+		if len(history) == 0 {
+			return nil
+		}
+		prev, cur := containerRestartCount(history[len(history)-1]), containerRestartCount(pod)
+		if cur > prev {
+			return fmt.Errorf("pod %v container restart count went from %v to %v", pod.Name, prev, cur)
+		}
+		return nil
+	}
+}
+
+func containerRestartCount(pod *api.Pod) int {
+	restarts := 0
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += int(status.RestartCount)
+	}
+	return restarts
+}
+
+// NoReschedulingOfRunningPods returns a PodInvariant that fails if a pod
+// that was previously seen Running shows up later bound to a different
+// node. Pods are matched by name only; a deletion between the two
+// observations is not taken into account.
+func NoReschedulingOfRunningPods() PodInvariant {
+	return func(event string, pod *api.Pod, history []*api.Pod) error {
+		if pod.Spec.NodeName == "" {
+			return nil
+		}
+		for _, prev := range history {
+			if prev.Status.Phase == api.PodRunning && prev.Spec.NodeName != "" && prev.Spec.NodeName != pod.Spec.NodeName {
+				return fmt.Errorf("pod %v seen Running on node %v was later bound to node %v", pod.Name, prev.Spec.NodeName, pod.Spec.NodeName)
+			}
+		}
+		return nil
+	}
+}
+
+// ReplicaCountStays returns a PodInvariant that tracks ADD/DEL events and
+// fails the moment the running count of pods it's seen drifts away from n.
+func ReplicaCountStays(n int) PodInvariant {
+	count := n
+	return func(event string, pod *api.Pod, history []*api.Pod) error {
+		switch event {
+		case ADD:
+			count++
+		case DEL:
+			count--
+		default:
+			return nil
+		}
+		if count != n {
+			return fmt.Errorf("replica count became %v (want %v) after a %v event for pod %v", count, n, event, pod.Name)
+		}
+		return nil
+	}
 }
 
 // replacePods replaces content of the store with the given pods.
@@ -198,10 +733,16 @@ var _ = framework.KubeDescribe("DaemonRestart [Disruptive]", func() {
 	var tracker *podTracker
 
 	BeforeEach(func() {
-		// These tests require SSH
-		framework.SkipUnlessProviderIs(framework.ProvidersWithSSH...)
 		ns = f.Namespace.Name
 
+		// On providers without node/master SSH access, restartDaemonConfig falls
+		// back to execing into a privileged shim pod instead (see
+		// ensureHostExecShim and defaultBackend), so there's no blanket provider
+		// skip here the way there used to be.
+		if defaultBackend() == BackendHostExec {
+			ensureHostExecShim(f.Client, ns)
+		}
+
 		// All the restart tests need an rc and a watch on pods of the rc.
 		// Additionally some of them might scale the rc during the test.
 		config = testutils.RCConfig{
@@ -243,18 +784,30 @@ var _ = framework.KubeDescribe("DaemonRestart [Disruptive]", func() {
 			},
 		)
 		go controller.Run(stopCh)
+
+		// The informer's first List synthesizes an ADD for every pod RunRC
+		// already created, so any invariant installed before that List lands
+		// (e.g. ReplicaCountStays, which has no notion of a baseline and just
+		// counts ADD/DEL) would race the initial sync burst and fail
+		// immediately. Block here until it's done so specs can install
+		// invariants right away without worrying about it.
+		err := wait.Poll(restartPollInterval, restartTimeout, func() (bool, error) {
+			return controller.HasSynced(), nil
+		})
+		framework.ExpectNoError(err, "timed out waiting for the daemonrestart pod informer to complete its initial sync")
 	})
 
 	AfterEach(func() {
 		close(stopCh)
+		if errs := tracker.Errors(); len(errs) > 0 {
+			framework.Failf("observed %d pod invariant violation(s): %v\n\n%+v", len(errs), errs, tracker)
+		}
 	})
 
 	It("Controller Manager should not create/delete replicas across restart", func() {
 
-		// Requires master ssh access.
-		framework.SkipUnlessProviderIs("gce", "aws")
-		restarter := NewRestartConfig(
-			framework.GetMasterHost(), "kube-controller", ports.ControllerManagerPort, restartPollInterval, restartTimeout)
+		tracker.AssertInvariants(ReplicaCountStays(numPods), NoReschedulingOfRunningPods())
+		restarter := newControlPlaneRestarter(f.Client, ns, "kube-controller", "component=kube-controller-manager", ports.ControllerManagerPort)
 		restarter.restart()
 
 		// The intent is to ensure the replication controller manager has observed and reported status of
@@ -282,10 +835,8 @@ var _ = framework.KubeDescribe("DaemonRestart [Disruptive]", func() {
 
 	It("Scheduler should continue assigning pods to nodes across restart", func() {
 
-		// Requires master ssh access.
-		framework.SkipUnlessProviderIs("gce", "aws")
-		restarter := NewRestartConfig(
-			framework.GetMasterHost(), "kube-scheduler", ports.SchedulerPort, restartPollInterval, restartTimeout)
+		tracker.AssertInvariants(NoPodDeletionsDuring(restartTimeout))
+		restarter := newControlPlaneRestarter(f.Client, ns, "kube-scheduler", "component=kube-scheduler", ports.SchedulerPort)
 
 		// Create pods while the scheduler is down and make sure the scheduler picks them up by
 		// scaling the rc to the same size.
@@ -300,21 +851,79 @@ var _ = framework.KubeDescribe("DaemonRestart [Disruptive]", func() {
 
 	It("Kubelet should not restart containers across restart", func() {
 
-		nodeIPs, err := getNodePublicIps(f.ClientSet)
+		tracker.AssertInvariants(NoContainerRestarts())
+		nodeTargets, err := kubeletRestartTargets(f)
 		framework.ExpectNoError(err)
 		preRestarts, badNodes := getContainerRestarts(f.Client, ns, labelSelector)
 		if preRestarts != 0 {
 			framework.Logf("WARNING: Non-zero container restart count: %d across nodes %v", preRestarts, badNodes)
 		}
-		for _, ip := range nodeIPs {
+		for _, target := range nodeTargets {
 			restarter := NewRestartConfig(
-				ip, "kubelet", ports.KubeletReadOnlyPort, restartPollInterval, restartTimeout)
+				f.Client, ns, target, "kubelet", ports.KubeletReadOnlyPort, restartPollInterval, restartTimeout)
 			restarter.restart()
 		}
-		postRestarts, badNodes := getContainerRestarts(f.Client, ns, labelSelector)
-		if postRestarts != preRestarts {
+		framework.Never(func() (bool, error) {
+			postRestarts, badNodes := getContainerRestarts(f.Client, ns, labelSelector)
+			if postRestarts == preRestarts {
+				return false, nil
+			}
 			framework.DumpNodeDebugInfo(f.Client, badNodes, framework.Logf)
-			framework.Failf("Net container restart count went from %v -> %v after kubelet restart on nodes %v \n\n %+v", preRestarts, postRestarts, badNodes, tracker)
+			return true, fmt.Errorf("net container restart count went from %v -> %v on nodes %v \n\n %+v", preRestarts, postRestarts, badNodes, tracker)
+		}, 3*restartPollInterval, restartPollInterval, "containers should not restart after kubelet restart")
+	})
+
+	It("Controller Manager should not create/delete replicas across a transient network partition", func() {
+
+		tracker.AssertInvariants(ReplicaCountStays(numPods), NoReschedulingOfRunningPods())
+		apiServer, err := apiServerIP()
+		framework.ExpectNoError(err)
+		restarter := newControlPlaneRestarter(f.Client, ns, "kube-controller", "component=kube-controller-manager", ports.ControllerManagerPort)
+		restarter.disrupt(apiServer, 2*restartPollInterval)
+
+		// Same rationale as the SIGTERM restart case above: scaling to the
+		// same size forces the controller manager to observe and report the
+		// RC's status, proving it had the opportunity to create/delete pods
+		// across the partition, if it were going to.
+		framework.ScaleRC(f.Client, f.ClientSet, ns, rcName, numPods, true)
+
+		existingKeys := sets.NewString()
+		newKeys := sets.NewString()
+		for _, k := range existingPods.ListKeys() {
+			existingKeys.Insert(k)
+		}
+		for _, k := range newPods.ListKeys() {
+			newKeys.Insert(k)
 		}
+		if len(newKeys.List()) != len(existingKeys.List()) ||
+			!newKeys.IsSuperset(existingKeys) {
+			framework.Failf("RcManager created/deleted pods during a transient partition \n\n %+v", tracker)
+		}
+	})
+
+	It("Kubelet should not restart containers on reconnect after a transient network partition", func() {
+
+		tracker.AssertInvariants(NoContainerRestarts())
+		nodeTargets, err := kubeletRestartTargets(f)
+		framework.ExpectNoError(err)
+		apiServer, err := apiServerIP()
+		framework.ExpectNoError(err)
+		preRestarts, badNodes := getContainerRestarts(f.Client, ns, labelSelector)
+		if preRestarts != 0 {
+			framework.Logf("WARNING: Non-zero container restart count: %d across nodes %v", preRestarts, badNodes)
+		}
+		for _, target := range nodeTargets {
+			restarter := NewRestartConfig(
+				f.Client, ns, target, "kubelet", ports.KubeletReadOnlyPort, restartPollInterval, restartTimeout)
+			restarter.disrupt(apiServer, 2*restartPollInterval)
+		}
+		framework.Never(func() (bool, error) {
+			postRestarts, badNodes := getContainerRestarts(f.Client, ns, labelSelector)
+			if postRestarts == preRestarts {
+				return false, nil
+			}
+			framework.DumpNodeDebugInfo(f.Client, badNodes, framework.Logf)
+			return true, fmt.Errorf("net container restart count went from %v -> %v on nodes %v \n\n %+v", preRestarts, postRestarts, badNodes, tracker)
+		}, 3*restartPollInterval, restartPollInterval, "containers should not restart on reconnect after a transient partition")
 	})
 })
@@ -0,0 +1,97 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package framework
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/wait"
+
+	"github.com/onsi/ginkgo"
+)
+
+// ConditionFunc is polled by Eventually and Never. Unlike wait.ConditionFunc,
+// a non-nil error is never swallowed and retried: it always means the
+// condition could not be evaluated (e.g. a failed SSH command) and the
+// calling spec should fail right away instead of waiting out the rest of the
+// timeout on a loop that can no longer succeed.
+type ConditionFunc func() (bool, error)
+
+// Eventually polls f every tick until it returns true, and fails the calling
+// Ginkgo spec if either f returns a non-nil error or waitFor elapses first.
+// This is the same shape as the ad-hoc `wait.Poll` + `framework.ExpectNoError`
+// pairs scattered across the e2e suite, except a transient error from f
+// surfaces immediately as a spec failure instead of being logged and retried
+// away until the timeout.
+func Eventually(f ConditionFunc, waitFor, tick time.Duration, msgAndArgs ...interface{}) {
+	ginkgo.GinkgoT().Helper()
+	var lastErr error
+	pollErr := wait.Poll(tick, waitFor, func() (bool, error) {
+		ok, err := f()
+		lastErr = err
+		return ok, err
+	})
+	if pollErr == nil {
+		return
+	}
+	if lastErr != nil {
+		Failf("%s: %v", formatWaitMsg(msgAndArgs), lastErr)
+	}
+	Failf("%s: timed out after %v", formatWaitMsg(msgAndArgs), waitFor)
+}
+
+// Never polls f every tick for the full waitFor duration and fails the
+// calling Ginkgo spec if f ever returns true, or a non-nil error. It's the
+// complement of Eventually, for asserting that something does *not* happen
+// within a window (no pod deletions during a restart, no container restarts
+// across a partition, etc) rather than that something eventually does.
+func Never(f ConditionFunc, waitFor, tick time.Duration, msgAndArgs ...interface{}) {
+	ginkgo.GinkgoT().Helper()
+	var lastVal bool
+	var lastErr error
+	pollErr := wait.Poll(tick, waitFor, func() (bool, error) {
+		ok, err := f()
+		lastVal, lastErr = ok, err
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, fmt.Errorf("condition became true")
+		}
+		return false, nil
+	})
+	if pollErr == wait.ErrWaitTimeout {
+		// Polled for the full duration and the condition never fired: success.
+		return
+	}
+	if lastErr != nil {
+		Failf("%s: %v (last observed value: %v)", formatWaitMsg(msgAndArgs), lastErr, lastVal)
+	}
+	Failf("%s: condition became true within %v (last observed value: %v)", formatWaitMsg(msgAndArgs), waitFor, lastVal)
+}
+
+func formatWaitMsg(msgAndArgs []interface{}) string {
+	if len(msgAndArgs) == 0 {
+		return "condition"
+	}
+	format, ok := msgAndArgs[0].(string)
+	if !ok {
+		return fmt.Sprintf("%v", msgAndArgs[0])
+	}
+	return fmt.Sprintf(format, msgAndArgs[1:]...)
+}